@@ -22,11 +22,13 @@ package transform
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/gapid/core/app/benchmark"
 	"github.com/google/gapid/core/log"
 	"github.com/google/gapid/gapis/atom"
 	"github.com/google/gapid/gapis/config"
+	"github.com/google/gapid/gapis/metrics"
 	"github.com/google/gapid/gapis/resolve/dependencygraph"
 )
 
@@ -40,21 +42,29 @@ var (
 	deadCodeEliminationDataLiveCounter = benchmark.GlobalCounters.Integer("deadCodeElimination.data.live")
 )
 
+var (
+	deadCodeEliminationKey          = []string{"deadCodeElimination", "propagateLiveness"}
+	deadCodeEliminationIterationKey = []string{"deadCodeElimination", "iteration"}
+	deadCodeEliminationFanOutKey    = []string{"deadCodeElimination", "liveness", "fanOut"}
+)
+
 // DeadCodeElimination is an implementation of Transformer that outputs live atoms.
 // That is, all atoms which to not affect the requested output are omitted.
 // The transform generates atoms from the given AtomsID, it does not take inputs.
 // It is named after the standard compiler optimization.
 // (state is like memory and atoms are instructions which read/write it).
 type DeadCodeElimination struct {
+	// dependencyGraph is resolved lazily by Flush, once lastRequest is
+	// final, so that it covers only the capture's chunks up to and including
+	// the one holding lastRequest. See dependencygraph.GetDependencyGraphRange.
 	dependencyGraph *dependencygraph.DependencyGraph
 	requests        atom.IDSet
 	lastRequest     atom.ID
 }
 
-func NewDeadCodeElimination(ctx context.Context, dependencyGraph *dependencygraph.DependencyGraph) *DeadCodeElimination {
+func NewDeadCodeElimination(ctx context.Context) *DeadCodeElimination {
 	return &DeadCodeElimination{
-		dependencyGraph: dependencyGraph,
-		requests:        make(atom.IDSet),
+		requests: make(atom.IDSet),
 	}
 }
 
@@ -72,6 +82,13 @@ func (t *DeadCodeElimination) Transform(ctx context.Context, id atom.ID, a atom.
 
 func (t *DeadCodeElimination) Flush(ctx context.Context, out Writer) {
 	t0 := deadCodeEliminationCounter.Start()
+	g, err := dependencygraph.GetDependencyGraphRange(ctx, t.lastRequest)
+	if err != nil {
+		log.E(ctx, "DCE: failed to resolve dependency graph up to atom %v: %v", t.lastRequest, err)
+		deadCodeEliminationCounter.Stop(t0)
+		return
+	}
+	t.dependencyGraph = g
 	isLive := t.propagateLiveness(ctx)
 	deadCodeEliminationCounter.Stop(t0)
 	for i, live := range isLive {
@@ -83,9 +100,14 @@ func (t *DeadCodeElimination) Flush(ctx context.Context, out Writer) {
 
 // See https://en.wikipedia.org/wiki/Live_variable_analysis
 func (t *DeadCodeElimination) propagateLiveness(ctx context.Context) []bool {
+	propagateStart := time.Now()
+	defer metrics.Get().MeasureSince(deadCodeEliminationKey, propagateStart)
+	metrics.Get().EmitKey(deadCodeEliminationFanOutKey, float32(len(t.dependencyGraph.GetHierarchyStateMap())))
+
 	isLive := make([]bool, t.lastRequest+1)
 	state := newLivenessTree(t.dependencyGraph.GetHierarchyStateMap())
 	for i := int(t.lastRequest); i >= 0; i-- {
+		iterStart := time.Now()
 		b := t.dependencyGraph.Behaviours[i]
 		isLive[i] = b.KeepAlive
 		// Always ignore commands that abort.
@@ -130,6 +152,7 @@ func (t *DeadCodeElimination) propagateLiveness(ctx context.Context) []bool {
 			log.I(ctx, "DCE: Requested atom %v: %v", i, t.dependencyGraph.Atoms[i])
 			t.dependencyGraph.Print(ctx, &b)
 		}
+		metrics.Get().MeasureSince(deadCodeEliminationIterationKey, iterStart)
 	}
 
 	{
@@ -164,6 +187,15 @@ func (t *DeadCodeElimination) propagateLiveness(ctx context.Context) []bool {
 		deadCodeEliminationDrawLiveCounter.AddInt64(int64(numLiveDraws))
 		deadCodeEliminationDataDeadCounter.AddInt64(int64(deadMem))
 		deadCodeEliminationDataLiveCounter.AddInt64(int64(liveMem))
+
+		sink := metrics.Get()
+		sink.EmitKey([]string{"deadCodeElimination", "atom", "dead"}, float32(numDead))
+		sink.EmitKey([]string{"deadCodeElimination", "atom", "live"}, float32(numLive))
+		sink.EmitKey([]string{"deadCodeElimination", "draw", "dead"}, float32(numDeadDraws))
+		sink.EmitKey([]string{"deadCodeElimination", "draw", "live"}, float32(numLiveDraws))
+		sink.EmitKey([]string{"deadCodeElimination", "data", "dead"}, float32(deadMem))
+		sink.EmitKey([]string{"deadCodeElimination", "data", "live"}, float32(liveMem))
+
 		log.D(ctx, "DCE: dead: %v%% %v cmds %v MB %v draws, live: %v%% %v cmds %v MB %v draws",
 			100*numDead/num, numDead, deadMem/1024/1024, numDeadDraws,
 			100*numLive/num, numLive, liveMem/1024/1024, numLiveDraws)
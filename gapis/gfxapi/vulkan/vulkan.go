@@ -58,7 +58,11 @@ func (api) GetFramebufferAttachmentInfo(state *gfxapi.State, attachment gfxapi.F
 	w, h, form, i, err := GetState(state).getFramebufferAttachmentInfo(attachment)
 	switch attachment {
 	case gfxapi.FramebufferAttachment_Stencil:
-		return 0, 0, 0, nil, fmt.Errorf("Unsupported Stencil")
+		format, err := getStencilImageFormatFromVulkanFormat(form)
+		if err != nil {
+			return 0, 0, 0, nil, fmt.Errorf("Unknown format for Stencil attachment")
+		}
+		return w, h, i, format, err
 	case gfxapi.FramebufferAttachment_Depth:
 		format, err := getDepthImageFormatFromVulkanFormat(form)
 		if err != nil {
@@ -74,6 +78,25 @@ func (api) GetFramebufferAttachmentInfo(state *gfxapi.State, attachment gfxapi.F
 	}
 }
 
+// getStencilImageFormatFromVulkanFormat returns the image.Format of the
+// stencil plane of vkfmt. Only VK_FORMAT_S8_UINT is supported: it is a
+// single-plane format, so the bytes getFramebufferAttachmentInfo reads
+// back are already the stencil plane. The combined depth/stencil formats
+// (D24_UNORM_S8_UINT, D32_SFLOAT_S8_UINT) pack the stencil byte inside a
+// wider depth/stencil texel, and the replay-side attachment resolver does
+// not yet know how to read back a single plane of a packed format - until
+// it does, reporting S8_UINT for those would read the whole packed texel
+// as if it were one stencil byte, so they stay an explicit error rather
+// than silently returning wrong bytes.
+func getStencilImageFormatFromVulkanFormat(vkfmt VkFormat) (*image.Format, error) {
+	switch vkfmt {
+	case VkFormat_VK_FORMAT_S8_UINT:
+		return getImageFormatFromVulkanFormat(vkfmt)
+	default:
+		return nil, fmt.Errorf("Unsupported Stencil format: %v", vkfmt)
+	}
+}
+
 // Mesh implements the gfxapi.MeshProvider interface
 func (api) Mesh(ctx context.Context, o interface{}, p *path.Mesh) (*gfxapi.Mesh, error) {
 	switch dc := o.(type) {
@@ -131,7 +154,155 @@ func (api) ResolveSynchronization(ctx context.Context, d *sync.Data, c *path.Cap
 var _ sync.SynchronizedAPI = &api{}
 
 func (api) GetTerminator(ctx context.Context, c *path.Capture) (transform.Terminator, error) {
-	return NewVulkanTerminator(ctx, c)
+	return newVulkanSubcommandTerminator(), nil
+}
+
+// vulkanSubcommandTerminator is a transform.Terminator that can stop replay
+// at a specific subcommand inside a VkQueueSubmit, not just at atom
+// boundaries. Requested points are registered through Add the same way
+// NewVulkanTerminator's whole-atom requests are, but an index with more
+// than one element drives the submit's command buffers via the same
+// CustomState.HandleSubcommand hook MutateSubcommands uses, and cancels
+// the context the instant the nested index is reached so the *single*
+// authoritative Mutate call that records this atom for replay stops
+// encoding further subcommands instead of emitting the whole submit.
+type vulkanSubcommandTerminator struct {
+	requests []sync.SubcommandIndex
+	reached  bool
+}
+
+func newVulkanSubcommandTerminator() *vulkanSubcommandTerminator {
+	return &vulkanSubcommandTerminator{}
+}
+
+// Add registers idx as a point at which replay should stop. idx[0]
+// addresses the root atom; any remaining elements select a subcommand
+// inside it, matching SubcommandAt.
+func (t *vulkanSubcommandTerminator) Add(ctx context.Context, id atom.ID, idx sync.SubcommandIndex) error {
+	t.requests = append(t.requests, idx)
+	return nil
+}
+
+// requestFor returns the registered request rooted at id, if any.
+func (t *vulkanSubcommandTerminator) requestFor(id atom.ID) (sync.SubcommandIndex, bool) {
+	for _, req := range t.requests {
+		if atom.ID(req[0]) == id {
+			return req, true
+		}
+	}
+	return nil, false
+}
+
+func (t *vulkanSubcommandTerminator) Transform(ctx context.Context, id atom.ID, a atom.Atom, out Writer) {
+	if t.reached {
+		return
+	}
+
+	req, ok := t.requestFor(id)
+	if !ok {
+		out.MutateAndWrite(ctx, id, a)
+		return
+	}
+	if len(req) == 1 {
+		out.MutateAndWrite(ctx, id, a)
+		t.reached = true
+		return
+	}
+
+	// Drive the one Mutate call that actually records this atom for replay
+	// (inside MutateAndWrite) with a context that gets canceled the moment
+	// HandleSubcommand reports the requested subcommand, so the submit is
+	// truncated at that point rather than replayed in full and re-mutated
+	// afterwards to check for a match.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	subcommand := req[1:]
+	c := GetState(out.State())
+	c.HandleSubcommand = func(_ interface{}) {
+		if subcommandIndexEquals(c.SubcommandIndex, subcommand) {
+			t.reached = true
+			cancel()
+		}
+	}
+	out.MutateAndWrite(ctx, id, a)
+	c.HandleSubcommand = nil
+}
+
+func (t *vulkanSubcommandTerminator) Flush(ctx context.Context, out Writer) {}
+
+// SubcommandAt implements sync.SynchronizedAPI. idx[0] addresses the root
+// atom (typically a VkQueueSubmit); any remaining elements select a
+// subcommand inside it the same way HandleSubcommand does while replaying.
+// It returns the root atom's ID and atom once replay confirms that
+// subcommand exists, so callers like GetFramebufferAttachmentInfo, Mesh and
+// Memory can all be driven from the same nested index.
+func (api) SubcommandAt(ctx context.Context, c *path.Capture, idx sync.SubcommandIndex) (atom.ID, atom.Atom, error) {
+	if len(idx) == 0 {
+		return 0, nil, fmt.Errorf("SubcommandAt requires a non-empty index")
+	}
+	rootIdx := atom.ID(idx[0])
+	a, err := resolve.Atoms(ctx, c)
+	if err != nil {
+		return 0, nil, err
+	}
+	if rootIdx >= atom.ID(len(a.Atoms)) {
+		return 0, nil, fmt.Errorf("Atom %v out of range", rootIdx)
+	}
+	root := a.Atoms[rootIdx]
+	if len(idx) == 1 {
+		return rootIdx, root, nil
+	}
+
+	ctx = capture.Put(ctx, c)
+	st, err := capture.NewState(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, cmd := range a.Atoms[:rootIdx] {
+		if err := cmd.Mutate(ctx, st, nil); err == context.Canceled {
+			return 0, nil, err
+		}
+	}
+
+	// Cancel ctx as soon as the requested subcommand is reached so
+	// MutateSubcommands (and, transitively, root.Mutate) stops replaying the
+	// rest of the submit instead of running it to completion just to
+	// confirm a match we already found.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	subcommand := idx[1:]
+	found := false
+	if err := (api{}).MutateSubcommands(ctx, root, rootIdx, st,
+		func(state *gfxapi.State, commandIndex sync.SubcommandIndex, a atom.Atom) {
+			if subcommandIndexEquals(commandIndex[1:], subcommand) {
+				found = true
+				cancel()
+			}
+		}); err != nil && err != context.Canceled {
+		return 0, nil, err
+	}
+	if !found {
+		return 0, nil, fmt.Errorf("Subcommand %v not found", idx)
+	}
+	return rootIdx, root, nil
+}
+
+// subcommandIndexEquals returns true if a and b identify the same subcommand.
+// resolve.Memory needs the identical comparison on its own SubcommandIndex
+// values; duplicated here rather than imported since sync.SubcommandIndex
+// doesn't expose it as a method.
+func subcommandIndexEquals(a, b sync.SubcommandIndex) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // GetDependencyGraphBehaviourProvider implements dependencygraph.DependencyGraphBehaviourProvider interface
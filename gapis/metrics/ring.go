@@ -0,0 +1,84 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is a single instrumentation event as recorded by a RingSink.
+type Sample struct {
+	Key    string
+	Value  float32
+	Labels []Label
+	Time   time.Time
+}
+
+// RingSink is a Sink that keeps the last N samples in memory. It requires
+// no external dependencies, so it is a reasonable default to register when
+// nothing else is configured but `log.D` output alone is not enough (e.g.
+// a UI wants to poll for the latest DCE/dependency-graph counters).
+type RingSink struct {
+	mu      sync.Mutex
+	samples []Sample
+	next    int
+	full    bool
+}
+
+// NewRingSink creates a RingSink that retains at most size samples.
+func NewRingSink(size int) *RingSink {
+	return &RingSink{samples: make([]Sample, size)}
+}
+
+func (r *RingSink) record(key []string, val float32, labels []Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = Sample{Key: strings.Join(key, "."), Value: val, Labels: labels, Time: time.Now()}
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// EmitKey implements Sink.
+func (r *RingSink) EmitKey(key []string, val float32, labels ...Label) { r.record(key, val, labels) }
+
+// IncrCounter implements Sink.
+func (r *RingSink) IncrCounter(key []string, val float32, labels ...Label) { r.record(key, val, labels) }
+
+// AddSample implements Sink.
+func (r *RingSink) AddSample(key []string, val float32, labels ...Label) { r.record(key, val, labels) }
+
+// MeasureSince implements Sink.
+func (r *RingSink) MeasureSince(key []string, start time.Time, labels ...Label) {
+	r.record(key, float32(time.Since(start).Seconds()), labels)
+}
+
+// Samples returns a snapshot of the retained samples, oldest first.
+func (r *RingSink) Samples() []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		out := make([]Sample, r.next)
+		copy(out, r.samples[:r.next])
+		return out
+	}
+	out := make([]Sample, len(r.samples))
+	copy(out, r.samples[r.next:])
+	copy(out[len(r.samples)-r.next:], r.samples[:r.next])
+	return out
+}
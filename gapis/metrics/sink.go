@@ -0,0 +1,89 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics defines a pluggable destination for the instrumentation
+// emitted by long-running gapis passes (dependency graph construction, dead
+// code elimination, ...), modelled after the armon/go-metrics sink
+// interface so existing statsd/Prometheus exporters can be wired in through
+// a thin adapter.
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Label is a key/value annotation attached to a sample, e.g. the API a
+// counter was recorded for.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sink receives instrumentation events emitted by gapis subsystems.
+// Implementations may fan samples out to an in-memory ring, a statsd
+// daemon, a Prometheus scrape endpoint, or anywhere else. A Sink must be
+// safe to call from multiple goroutines.
+type Sink interface {
+	// EmitKey sets a gauge-like value for key, overwriting any prior value.
+	EmitKey(key []string, val float32, labels ...Label)
+	// IncrCounter increments the counter identified by key by val.
+	IncrCounter(key []string, val float32, labels ...Label)
+	// AddSample records val as an observation in the key's distribution.
+	AddSample(key []string, val float32, labels ...Label)
+	// MeasureSince records the elapsed time since start under key.
+	MeasureSince(key []string, start time.Time, labels ...Label)
+}
+
+// defaultRingSize bounds the RingSink Get returns before anything has
+// called Register, so the instrumented call sites record something
+// queryable even if the binary never wires up its own sink.
+const defaultRingSize = 4096
+
+var (
+	mu      sync.RWMutex
+	current Sink = NewRingSink(defaultRingSize)
+)
+
+// Register installs sink as the process-wide metrics destination,
+// replacing whatever was previously registered (including the default
+// RingSink); a nil sink discards all samples. The sink may be e.g.
+// metrics.NewRingSink(n) or an adapter over a statsd/Prometheus client.
+// gapis startup does not call Register, so unless some other caller does,
+// samples go to the default RingSink.
+func Register(sink Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	if sink == nil {
+		sink = nopSink{}
+	}
+	current = sink
+}
+
+// Get returns the currently registered sink. If nothing has called
+// Register yet, this is the default RingSink above, so instrumented
+// call-sites never need to nil-check and samples aren't silently dropped
+// when no other destination has been registered.
+func Get() Sink {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+type nopSink struct{}
+
+func (nopSink) EmitKey(key []string, val float32, labels ...Label)          {}
+func (nopSink) IncrCounter(key []string, val float32, labels ...Label)      {}
+func (nopSink) AddSample(key []string, val float32, labels ...Label)        {}
+func (nopSink) MeasureSince(key []string, start time.Time, labels ...Label) {}
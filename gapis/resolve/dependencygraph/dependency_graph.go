@@ -17,6 +17,8 @@ package dependencygraph
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/google/gapid/core/app/benchmark"
 	"github.com/google/gapid/core/log"
@@ -24,10 +26,18 @@ import (
 	"github.com/google/gapid/gapis/capture"
 	"github.com/google/gapid/gapis/database"
 	"github.com/google/gapid/gapis/gfxapi"
+	"github.com/google/gapid/gapis/metrics"
+	"github.com/google/gapid/gapis/service/path"
 )
 
 var dependencyGraphBuildCounter = benchmark.GlobalCounters.Duration("dependencyGraph.build")
 
+var (
+	dependencyGraphBuildKey      = []string{"dependencyGraph", "build"}
+	dependencyGraphAtomsKey      = []string{"dependencyGraph", "atoms"}
+	dependencyGraphStateCountKey = []string{"dependencyGraph", "stateAddresses"}
+)
+
 type DependencyGraph struct {
 	Atoms      []atom.Atom           // Atom list which this graph was build for.
 	Behaviours []AtomBehaviour       // State reads/writes for each atom (graph edges).
@@ -127,6 +137,14 @@ type DependencyGraphBehaviourProvider interface {
 
 type BehaviourProvider interface {
 	GetBehaviourForAtom(ctx context.Context, s *gfxapi.State, id atom.ID, g *DependencyGraph, a atom.Atom) AtomBehaviour
+	// Clone returns an independent copy of this provider, including
+	// whatever internal state it accumulates while building behaviours
+	// (e.g. a provider tracking in-flight command buffer recording).
+	// dependencyGraphChunkResolvable.Resolve clones a chunk boundary's
+	// providers before continuing to mutate them into the next chunk, so
+	// an earlier chunk's already-memoized provider is never changed out
+	// from under it.
+	Clone() BehaviourProvider
 }
 
 func GetDependencyGraph(ctx context.Context) (*DependencyGraph, error) {
@@ -143,11 +161,10 @@ func (r *DependencyGraphResolvable) Resolve(ctx context.Context) (interface{}, e
 		return nil, err
 	}
 	atoms := c.Atoms
-	behaviourProviders := map[gfxapi.API]BehaviourProvider{}
 
 	g := &DependencyGraph{
 		Atoms:      atoms,
-		Behaviours: make([]AtomBehaviour, len(atoms)),
+		Behaviours: make([]AtomBehaviour, 0, len(atoms)),
 		Roots:      map[StateAddress]bool{},
 		addressMap: addressMapping{
 			address: map[StateKey]StateAddress{nil: NullStateAddress},
@@ -157,8 +174,29 @@ func (r *DependencyGraphResolvable) Resolve(ctx context.Context) (interface{}, e
 	}
 
 	s := c.NewState()
+	behaviours, err := buildBehaviours(ctx, atoms, 0, atom.ID(len(atoms)), s, g, map[gfxapi.API]BehaviourProvider{})
+	if err != nil {
+		return nil, err
+	}
+	g.Behaviours = behaviours
+	return g, nil
+}
+
+// buildBehaviours mutates s through atoms[lo:hi] and returns the resulting
+// per-atom AtomBehaviour slice, resolving each API's BehaviourProvider
+// lazily as new APIs are encountered and caching it in behaviourProviders
+// so callers extending an earlier chunk can carry the same provider
+// instances forward instead of losing whatever state a provider like
+// Vulkan's accumulates while recording command buffers. g serves as the
+// StateKey -> StateAddress interner and collects any roots the providers
+// set through SetRoot; its Behaviours field is not touched.
+func buildBehaviours(ctx context.Context, atoms []atom.Atom, lo, hi atom.ID, s *gfxapi.State, g *DependencyGraph, behaviourProviders map[gfxapi.API]BehaviourProvider) ([]AtomBehaviour, error) {
+	out := make([]AtomBehaviour, 0, hi-lo)
+
 	t0 := dependencyGraphBuildCounter.Start()
-	for i, a := range g.Atoms {
+	buildStart := time.Now()
+	for i := lo; i < hi; i++ {
+		a := atoms[i]
 		api := a.API()
 		if _, ok := behaviourProviders[api]; !ok {
 			if bp, ok := api.(DependencyGraphBehaviourProvider); ok {
@@ -166,20 +204,216 @@ func (r *DependencyGraphResolvable) Resolve(ctx context.Context) (interface{}, e
 			} else {
 				// API does not provide dependency information, always keep atoms for
 				// such APIs.
-				g.Behaviours[i].KeepAlive = true
 				// Even if the atom does not belong to an API that provides dependency
 				// info, we still need to mutate it in the new state, because following
 				// atoms in other APIs may depends on the side effect of the current
 				// atom.
 				if err := a.Mutate(ctx, s, nil /* builder */); err != nil {
-					log.W(ctx, "Atom %v %v: %v", atom.ID(i), a, err)
-					return AtomBehaviour{Aborted: true}, nil
+					log.W(ctx, "Atom %v %v: %v", i, a, err)
+					out = append(out, AtomBehaviour{Aborted: true})
+					return out, nil
 				}
+				out = append(out, AtomBehaviour{KeepAlive: true})
 				continue
 			}
 		}
-		g.Behaviours[i] = behaviourProviders[api].GetBehaviourForAtom(ctx, s, atom.ID(i), g, a)
+		apiLabel := metrics.Label{Name: "api", Value: fmt.Sprintf("%v", api)}
+		providerStart := time.Now()
+		out = append(out, behaviourProviders[api].GetBehaviourForAtom(ctx, s, i, g, a))
+		metrics.Get().MeasureSince(dependencyGraphBuildKey, providerStart, apiLabel)
+		metrics.Get().IncrCounter(dependencyGraphAtomsKey, 1, apiLabel)
 	}
 	dependencyGraphBuildCounter.Stop(t0)
-	return g, nil
+	metrics.Get().MeasureSince(dependencyGraphBuildKey, buildStart)
+	metrics.Get().EmitKey(dependencyGraphStateCountKey, float32(len(g.addressMap.address)))
+	return out, nil
+}
+
+// dependencyGraphChunkSize bounds how many atoms worth of behaviours are
+// resolved and memoized together. DeadCodeElimination only ever needs the
+// prefix of the capture up to its highest requested atom, so chunking lets
+// GetDependencyGraphRange reuse every chunk below a request's high-water
+// mark instead of re-walking the whole capture each time Flush runs.
+const dependencyGraphChunkSize = atom.ID(2048)
+
+// dependencyGraphChunkID identifies a chunk of a capture's atom list:
+// atoms [chunk*dependencyGraphChunkSize, (chunk+1)*dependencyGraphChunkSize).
+type dependencyGraphChunkID struct {
+	capture *path.Capture
+	chunk   int
+}
+
+// dependencyGraphChunkResolvable is the database.Build key for one
+// memoized chunk of a capture's dependency graph. Resolving it recursively
+// resolves (and so reuses, via database's own de-duplication) chunk-1 at
+// chunk-1's current generation, so memoization, eviction and in-flight
+// de-duplication across concurrent callers all come from database rather
+// than a bespoke process-global cache.
+type dependencyGraphChunkResolvable struct {
+	Capture    *path.Capture
+	Chunk      int
+	Generation int
+}
+
+// dependencyGraphChunk is one resolved chunk: the DependencyGraph covering
+// everything up to and including this chunk, the mutated state at the
+// chunk boundary so the next chunk can resume mutation without starting
+// over from atom zero, and the BehaviourProviders accumulated so far so
+// a provider like Vulkan's doesn't lose command-buffer-recording state
+// that spans a chunk boundary.
+type dependencyGraphChunk struct {
+	graph     *DependencyGraph
+	state     *gfxapi.State
+	providers map[gfxapi.API]BehaviourProvider
+}
+
+// chunkGen tracks, per chunk, how many times InvalidateRange has dirtied
+// it. It only ever holds small integers - the actual graphs/states/
+// providers are memoized by database.Build, keyed in part on the
+// generation recorded here.
+var (
+	chunkGenMu sync.Mutex
+	chunkGen   = map[dependencyGraphChunkID]int{}
+)
+
+// currentGeneration returns the generation id is resolved at: the number
+// of times id's chunk or any earlier chunk of the same capture has been
+// dirtied, since every chunk is built on top of the one before it.
+func currentGeneration(id dependencyGraphChunkID) int {
+	chunkGenMu.Lock()
+	defer chunkGenMu.Unlock()
+	gen := 0
+	for other, n := range chunkGen {
+		if other.capture == id.capture && other.chunk <= id.chunk {
+			gen += n
+		}
+	}
+	return gen
+}
+
+// InvalidateRange forces every chunk overlapping atoms [lo, hi], and every
+// later chunk built on top of them, to be rebuilt the next time
+// GetDependencyGraphRange reaches it, without discarding the chunks before
+// lo. Nothing calls it today (atoms never change once captured); it gives
+// future edit/replay features a place to dirty a subrange of the graph.
+func InvalidateRange(c *path.Capture, lo, hi atom.ID) {
+	chunkGenMu.Lock()
+	defer chunkGenMu.Unlock()
+	first := int(lo / dependencyGraphChunkSize)
+	last := int(hi / dependencyGraphChunkSize)
+	for i := first; i <= last; i++ {
+		id := dependencyGraphChunkID{capture: c, chunk: i}
+		chunkGen[id]++
+	}
+}
+
+// GetDependencyGraphRange returns a DependencyGraph covering atoms
+// [0, hi], building only the chunks needed to extend the deepest
+// previously-resolved chunk rather than re-walking the whole capture.
+func GetDependencyGraphRange(ctx context.Context, hi atom.ID) (*DependencyGraph, error) {
+	c := capture.Get(ctx)
+	chunkIdx := int(hi / dependencyGraphChunkSize)
+	id := dependencyGraphChunkID{capture: c, chunk: chunkIdx}
+	r, err := database.Build(ctx, &dependencyGraphChunkResolvable{
+		Capture:    c,
+		Chunk:      chunkIdx,
+		Generation: currentGeneration(id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not calculate dependency graph: %v", err)
+	}
+	return r.(*dependencyGraphChunk).graph, nil
+}
+
+func (r *dependencyGraphChunkResolvable) Resolve(ctx context.Context) (interface{}, error) {
+	cp, err := capture.ResolveFromPath(ctx, r.Capture)
+	if err != nil {
+		return nil, err
+	}
+	atoms := cp.Atoms
+	lo := atom.ID(r.Chunk) * dependencyGraphChunkSize
+	hi := lo + dependencyGraphChunkSize
+	if hi > atom.ID(len(atoms)) {
+		hi = atom.ID(len(atoms))
+	}
+
+	var prev *dependencyGraphChunk
+	if r.Chunk > 0 {
+		prevID := dependencyGraphChunkID{capture: r.Capture, chunk: r.Chunk - 1}
+		pr, err := database.Build(ctx, &dependencyGraphChunkResolvable{
+			Capture:    r.Capture,
+			Chunk:      r.Chunk - 1,
+			Generation: currentGeneration(prevID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		prev = pr.(*dependencyGraphChunk)
+	}
+
+	// Extend a deep copy of the previous chunk's graph/state/providers
+	// rather than the memoized values themselves, so continuing to mutate
+	// into this chunk can never corrupt database's cached result for the
+	// previous one.
+	var g *DependencyGraph
+	var s *gfxapi.State
+	providers := map[gfxapi.API]BehaviourProvider{}
+	if prev != nil {
+		g = &DependencyGraph{
+			Atoms:      atoms[:hi],
+			Behaviours: append([]AtomBehaviour(nil), prev.graph.Behaviours...),
+			Roots:      copyRoots(prev.graph.Roots),
+			addressMap: copyAddressMap(prev.graph.addressMap),
+		}
+		s = prev.state.Clone()
+		for api, bp := range prev.providers {
+			providers[api] = bp.Clone()
+		}
+	} else {
+		g = &DependencyGraph{
+			Atoms:      atoms[:hi],
+			Behaviours: nil,
+			Roots:      map[StateAddress]bool{},
+			addressMap: addressMapping{
+				address: map[StateKey]StateAddress{nil: NullStateAddress},
+				key:     map[StateAddress]StateKey{NullStateAddress: nil},
+				parent:  map[StateAddress]StateAddress{NullStateAddress: NullStateAddress},
+			},
+		}
+		s = cp.NewState()
+	}
+
+	behaviours, err := buildBehaviours(ctx, atoms, lo, hi, s, g, providers)
+	if err != nil {
+		return nil, err
+	}
+	g.Behaviours = append(g.Behaviours, behaviours...)
+
+	return &dependencyGraphChunk{graph: g, state: s, providers: providers}, nil
+}
+
+func copyRoots(roots map[StateAddress]bool) map[StateAddress]bool {
+	out := make(map[StateAddress]bool, len(roots))
+	for k, v := range roots {
+		out[k] = v
+	}
+	return out
+}
+
+func copyAddressMap(m addressMapping) addressMapping {
+	out := addressMapping{
+		address: make(map[StateKey]StateAddress, len(m.address)),
+		key:     make(map[StateAddress]StateKey, len(m.key)),
+		parent:  make(map[StateAddress]StateAddress, len(m.parent)),
+	}
+	for k, v := range m.address {
+		out.address[k] = v
+	}
+	for k, v := range m.key {
+		out.key[k] = v
+	}
+	for k, v := range m.parent {
+		out.parent[k] = v
+	}
+	return out
 }
@@ -19,7 +19,10 @@ import (
 	"fmt"
 
 	"github.com/google/gapid/core/math/interval"
+	"github.com/google/gapid/gapis/atom"
 	"github.com/google/gapid/gapis/capture"
+	"github.com/google/gapid/gapis/gfxapi"
+	"github.com/google/gapid/gapis/gfxapi/sync"
 	"github.com/google/gapid/gapis/memory"
 	"github.com/google/gapid/gapis/service"
 	"github.com/google/gapid/gapis/service/path"
@@ -30,9 +33,7 @@ func Memory(ctx context.Context, p *path.Memory) (*service.Memory, error) {
 	ctx = capture.Put(ctx, path.FindCapture(p))
 
 	atomIdx := p.After.Indices[0]
-	if len(p.After.Indices) > 1 {
-		return nil, fmt.Errorf("Subcommands currently not supported for Memory") // TODO: Subcommands
-	}
+	subcommand := sync.SubcommandIndex(p.After.Indices[1:])
 
 	list, err := NAtoms(ctx, p.After.Capture, atomIdx+1)
 	if err != nil {
@@ -44,7 +45,7 @@ func Memory(ctx context.Context, p *path.Memory) (*service.Memory, error) {
 		return nil, err
 	}
 	for _, a := range list.Atoms[:atomIdx] {
-		if err := a.Mutate(ctx, s, nil); err != nil && err == context.Canceled {
+		if err := a.Mutate(ctx, s, nil); err == context.Canceled {
 			return nil, err
 		}
 	}
@@ -56,18 +57,54 @@ func Memory(ctx context.Context, p *path.Memory) (*service.Memory, error) {
 
 	r := memory.Range{Base: p.Address, Size: p.Size}
 
+	// capturing gates the OnRead/OnWrite callbacks below so that, when a
+	// subcommand is requested, only the accesses made by that particular
+	// subcommand are recorded rather than everything the enclosing
+	// VkQueueSubmit touches.
+	capturing := true
 	var reads, writes, observed memory.RangeList
 	pool.OnRead = func(rng memory.Range) {
-		if rng.Overlaps(r) {
+		if capturing && rng.Overlaps(r) {
 			interval.Merge(&reads, rng.Window(r).Span(), false)
 		}
 	}
 	pool.OnWrite = func(rng memory.Range) {
-		if rng.Overlaps(r) {
+		if capturing && rng.Overlaps(r) {
 			interval.Merge(&writes, rng.Window(r).Span(), false)
 		}
 	}
-	list.Atoms[atomIdx].Mutate(ctx, s, nil /* no builder, just mutate */)
+
+	target := list.Atoms[atomIdx]
+	if len(subcommand) == 0 {
+		target.Mutate(ctx, s, nil /* no builder, just mutate */)
+	} else {
+		api, ok := target.API().(sync.SynchronizedAPI)
+		if !ok {
+			return nil, fmt.Errorf("Subcommands not supported for API %v", target.API())
+		}
+		capturing = false
+
+		// Cancel ctx as soon as the target subcommand is reached so
+		// MutateSubcommands stops replaying the rest of the submit (as the
+		// Vulkan SubcommandAt does) instead of running it to completion -
+		// otherwise Reads/Writes would reflect the target subcommand while
+		// the pool slice read out below would reflect whatever a later
+		// subcommand in the same submit wrote over it.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		if err := api.MutateSubcommands(ctx, target, atomIdx, s,
+			func(state *gfxapi.State, idx sync.SubcommandIndex, a atom.Atom) {
+				// idx is rooted at atomIdx (see MutateSubcommands), so strip
+				// that leading element before comparing against subcommand,
+				// which is nested-only.
+				capturing = subcommandIndexEquals(idx[1:], subcommand)
+				if capturing {
+					cancel()
+				}
+			}); err != nil && err != context.Canceled {
+			return nil, err
+		}
+	}
 
 	slice := pool.Slice(r)
 
@@ -90,3 +127,16 @@ func Memory(ctx context.Context, p *path.Memory) (*service.Memory, error) {
 		Observed: service.NewMemoryRanges(observed),
 	}, nil
 }
+
+// subcommandIndexEquals returns true if a and b identify the same subcommand.
+func subcommandIndexEquals(a, b sync.SubcommandIndex) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}